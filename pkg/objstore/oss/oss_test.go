@@ -0,0 +1,263 @@
+package oss
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/aliyun/aliyun-oss-go-sdk/oss"
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestRAMRoleCredentialsProvider_RefreshesBeforeExpiry(t *testing.T) {
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		_ = json.NewEncoder(w).Encode(ecsRAMRoleCredentialsResponse{
+			Code:            "Success",
+			AccessKeyID:     "fake-id",
+			AccessKeySecret: "fake-secret",
+			SecurityToken:   "fake-token",
+			Expiration:      time.Now().Add(time.Hour),
+		})
+	}))
+	defer srv.Close()
+
+	p := newRAMRoleCredentialsProvider("test-role", log.NewNopLogger())
+	p.metadataURL = srv.URL
+
+	creds := p.GetCredentials()
+	if creds.GetAccessKeyID() != "fake-id" || creds.GetAccessKeySecret() != "fake-secret" || creds.GetSecurityToken() != "fake-token" {
+		t.Fatalf("unexpected credentials: %+v", creds)
+	}
+	if requests != 1 {
+		t.Fatalf("expected 1 metadata request, got %d", requests)
+	}
+
+	// A second call before the 80%-of-lifetime refresh point should reuse the cached
+	// credentials rather than hitting the metadata service again.
+	if _, ok := p.GetCredentials().(ramCredentials); !ok {
+		t.Fatalf("expected cached credentials to be returned")
+	}
+	if requests != 1 {
+		t.Fatalf("expected no additional metadata request before refresh is due, got %d", requests)
+	}
+
+	// Force the refresh point into the past and verify a fresh fetch happens.
+	p.mu.Lock()
+	p.refreshAt = time.Now().Add(-time.Second)
+	p.mu.Unlock()
+
+	p.GetCredentials()
+	if requests != 2 {
+		t.Fatalf("expected a second metadata request after refresh became due, got %d", requests)
+	}
+}
+
+func TestRAMRoleCredentialsProvider_KeepsLastKnownCredentialsOnFailedRefresh(t *testing.T) {
+	var fail bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(ecsRAMRoleCredentialsResponse{
+			Code:            "Success",
+			AccessKeyID:     "fake-id",
+			AccessKeySecret: "fake-secret",
+			SecurityToken:   "fake-token",
+			Expiration:      time.Now().Add(time.Hour),
+		})
+	}))
+	defer srv.Close()
+
+	p := newRAMRoleCredentialsProvider("test-role", log.NewNopLogger())
+	p.metadataURL = srv.URL
+
+	if creds := p.GetCredentials(); creds.GetAccessKeyID() != "fake-id" {
+		t.Fatalf("unexpected credentials: %+v", creds)
+	}
+
+	fail = true
+	p.mu.Lock()
+	p.refreshAt = time.Now().Add(-time.Second)
+	p.mu.Unlock()
+
+	creds := p.GetCredentials()
+	if creds.GetAccessKeyID() != "fake-id" {
+		t.Fatalf("expected last known credentials to survive a failed refresh, got: %+v", creds)
+	}
+}
+
+func TestObjectOptions(t *testing.T) {
+	if _, err := objectOptions(Config{SSE: SSEConfig{Type: "bogus"}}); err == nil {
+		t.Fatal("expected an error for an unsupported sse.type")
+	}
+	if _, err := objectOptions(Config{StorageClass: "bogus"}); err == nil {
+		t.Fatal("expected an error for an unsupported storage_class")
+	}
+	if _, err := objectOptions(Config{SSE: SSEConfig{Type: "KMS", KMSKeyID: "my-key"}, StorageClass: "IA"}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+}
+
+func TestContentType(t *testing.T) {
+	for name, want := range map[string]string{
+		"meta.json":     "application/json",
+		"index":         "application/octet-stream",
+		"chunks/000001": "application/octet-stream",
+	} {
+		if got := contentType(name); got != want {
+			t.Errorf("contentType(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestIsRetryableErr(t *testing.T) {
+	if !isRetryableErr(oss.ServiceError{StatusCode: 503, Code: "ServiceUnavailable"}) {
+		t.Fatal("expected a 5xx service error to be retryable")
+	}
+	if !isRetryableErr(oss.ServiceError{StatusCode: 400, Code: "RequestTimeout"}) {
+		t.Fatal("expected RequestTimeout to be retryable")
+	}
+	if isRetryableErr(oss.ServiceError{StatusCode: 404, Code: "NoSuchKey"}) {
+		t.Fatal("expected NoSuchKey not to be retryable")
+	}
+	if isRetryableErr(errors.New("some other error")) {
+		t.Fatal("expected an unrecognized error not to be retryable")
+	}
+	// A connection reset is typically wrapped as a *net.OpError, which itself satisfies
+	// net.Error with Timeout() == false; it must still be retried.
+	resetErr := &net.OpError{Op: "read", Err: syscall.ECONNRESET}
+	if !isRetryableErr(resetErr) {
+		t.Fatal("expected a wrapped ECONNRESET to be retryable")
+	}
+	if isRetryableErr(&net.OpError{Op: "dial", Err: errors.New("some other net error")}) {
+		t.Fatal("expected a non-timeout, non-reset net.Error not to be retryable")
+	}
+}
+
+func TestIsObjNotFoundErr(t *testing.T) {
+	b := &Bucket{}
+	if !b.IsObjNotFoundErr(oss.ServiceError{StatusCode: 404, Code: "NoSuchKey"}) {
+		t.Fatal("expected a typed NoSuchKey service error to be recognized")
+	}
+	if b.IsObjNotFoundErr(nil) {
+		t.Fatal("expected nil not to be recognized as not-found")
+	}
+	if b.IsObjNotFoundErr(errors.New("unrelated")) {
+		t.Fatal("expected an unrelated error not to be recognized as not-found")
+	}
+}
+
+func TestBucketCall_RespectsContextCancellation(t *testing.T) {
+	b := &Bucket{}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := b.call(ctx, func() error {
+		t.Fatal("fn should not run once ctx is already canceled")
+		return nil
+	})
+	if err == nil {
+		t.Fatal("expected an error when ctx is canceled before the call")
+	}
+}
+
+func TestBucketCall_SucceedsOnFreshAbortContext(t *testing.T) {
+	b := &Bucket{}
+
+	// Reproduces the context Upload's abort closure builds for its AbortMultipartUpload call:
+	// abort is triggered by the request's own context failing (often by being canceled), so it
+	// cannot reuse that context the way call()'s ctx.Done()/ctx.Err() checks would require, or
+	// call would reject fn before it ever ran and the upload would leak on the bucket.
+	abortCtx, abortCancel := context.WithTimeout(context.Background(), abortTimeout)
+	defer abortCancel()
+
+	var ran bool
+	if err := b.call(abortCtx, func() error {
+		ran = true
+		return nil
+	}); err != nil {
+		t.Fatalf("expected abort's fresh context call to succeed, got %v", err)
+	}
+	if !ran {
+		t.Fatal("expected fn to run on the fresh abort context")
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	for name, tc := range map[string]struct {
+		conf    Config
+		wantErr bool
+	}{
+		"missing bucket": {
+			conf:    Config{Endpoint: "oss-cn-hangzhou.aliyuncs.com", AccessID: "id", AccessKey: "key"},
+			wantErr: true,
+		},
+		"static keys": {
+			conf: Config{Bucket: "b", Endpoint: "e", AccessID: "id", AccessKey: "key"},
+		},
+		"ram role without static keys": {
+			conf: Config{Bucket: "b", Endpoint: "e", RAMRole: "role"},
+		},
+		"neither static keys nor ram role": {
+			conf:    Config{Bucket: "b", Endpoint: "e"},
+			wantErr: true,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := tc.conf.validate()
+			if tc.wantErr && err == nil {
+				t.Fatal("expected an error, got nil")
+			}
+			if !tc.wantErr && err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestNewBucket_DefaultsParallelismToOne(t *testing.T) {
+	bc, err := yaml.Marshal(Config{Bucket: "b", Endpoint: "oss-cn-hangzhou.aliyuncs.com", AccessID: "id", AccessKey: "key"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewBucket(log.NewNopLogger(), bc, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.parallelism != 1 {
+		t.Fatalf("expected default parallelism of 1, got %d", b.parallelism)
+	}
+
+	bc, err = yaml.Marshal(Config{Bucket: "b", Endpoint: "oss-cn-hangzhou.aliyuncs.com", AccessID: "id", AccessKey: "key", Parallelism: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err = NewBucket(log.NewNopLogger(), bc, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.parallelism != 4 {
+		t.Fatalf("expected configured parallelism of 4, got %d", b.parallelism)
+	}
+}
+
+func TestValidateForTest(t *testing.T) {
+	if err := validateForTest(Config{}); err == nil {
+		t.Fatal("expected an error when no OSS test env vars are set")
+	}
+	// OSS_BUCKET is intentionally optional: NewTestBucket creates a temporary bucket itself
+	// when it's unset.
+	if err := validateForTest(Config{Endpoint: "e", AccessID: "id", AccessKey: "key"}); err != nil {
+		t.Fatalf("expected no error without OSS_BUCKET set, got %v", err)
+	}
+}